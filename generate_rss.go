@@ -17,8 +17,15 @@ import (
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/gmemstr/feeds"
+	"github.com/gmemstr/pogo/activitypub"
+	"github.com/gmemstr/pogo/shows"
 )
 
+type Category struct {
+	Name        string `json:"name"`
+	Subcategory string `json:"subcategory,omitempty"`
+}
+
 type Config struct {
 	Name        string
 	Host        string
@@ -26,15 +33,131 @@ type Config struct {
 	Description string
 	Image       string
 	PodcastUrl  string
+
+	// The following are used to populate the itunes: namespace so that
+	// directories such as Apple Podcasts, Overcast and Pocket Casts can
+	// properly list and categorize the show.
+	Subtitle   string
+	Summary    string
+	OwnerName  string
+	OwnerEmail string
+	Explicit   bool
+	Type       string // "episodic" or "serial"
+	Language   string
+	Copyright  string
+	Categories []Category
+
+	// EnableActivityPub turns the instance into a followable
+	// ActivityPub actor; see the activitypub package.
+	EnableActivityPub   bool
+	ActivityPubUsername string
+}
+
+// EpisodeMeta holds the optional per-episode iTunes metadata read from a
+// companion "<episode>_META.json" file sitting next to the mp3 and
+// shownotes in podcasts/. Any field left unset is simply omitted from
+// the feed.
+type EpisodeMeta struct {
+	Subtitle    string `json:"subtitle"`
+	Summary     string `json:"summary"`
+	Season      int    `json:"season"`
+	Episode     int    `json:"episode"`
+	EpisodeType string `json:"episodeType"`
+	Duration    string `json:"duration"`
+}
+
+// readEpisodeMeta reads and parses a "_META.json" file for an episode.
+// Its absence isn't an error - not every episode needs season/episode
+// numbering or a subtitle.
+func readEpisodeMeta(path string) EpisodeMeta {
+	var meta EpisodeMeta
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return meta
+	}
+	if err := json.Unmarshal(d, &meta); err != nil {
+		log.Println("error parsing episode metadata:", err)
+	}
+	return meta
+}
+
+// itunesCategories converts the configured categories into the
+// feeds.ITunesCategory tree, pairing each category with its subcategory
+// when one is set.
+func itunesCategories(categories []Category) []*feeds.ITunesCategory {
+	var out []*feeds.ITunesCategory
+	for _, c := range categories {
+		cat := &feeds.ITunesCategory{Text: c.Name}
+		if c.Subcategory != "" {
+			cat.Subcategory = &feeds.ITunesCategory{Text: c.Subcategory}
+		}
+		out = append(out, cat)
+	}
+	return out
+}
+
+// announcedEpisode carries just enough about a newly published episode
+// to federate it out over ActivityPub.
+type announcedEpisode struct {
+	name  string
+	title string
+	link  string
+}
+
+// knownEpisodesPath returns the path of the file that tracks which
+// episodes of a show GenerateRss has already seen, so restarts don't
+// re-announce the whole back catalogue over ActivityPub.
+func knownEpisodesPath(slug string) string {
+	return shows.Dir(slug) + "/.known_episodes.json"
+}
+
+// loadKnownEpisodes returns the set of episode filenames GenerateRss has
+// already seen for a show, so it can tell which ones are new since
+// last run.
+func loadKnownEpisodes(slug string) map[string]bool {
+	known := map[string]bool{}
+	d, err := ioutil.ReadFile(knownEpisodesPath(slug))
+	if err != nil {
+		return known
+	}
+	var names []string
+	if err := json.Unmarshal(d, &names); err != nil {
+		return known
+	}
+	for _, name := range names {
+		known[name] = true
+	}
+	return known
 }
 
-// Watch folder for changes, called from webserver.go
+func saveKnownEpisodes(slug string, known map[string]bool) {
+	names := make([]string, 0, len(known))
+	for name := range known {
+		names = append(names, name)
+	}
+	b, err := json.Marshal(names)
+	if err != nil {
+		log.Println("error marshaling known episodes:", err)
+		return
+	}
+	ioutil.WriteFile(knownEpisodesPath(slug), b, 0644)
+}
+
+// showWatcher is the running fsnotify watcher set up by watch(), kept
+// at package scope so addShowWatch can register a show created after
+// startup instead of only the ones watch() found on boot.
+var showWatcher *fsnotify.Watcher
+
+// Watch every show's directory and config for changes, called from
+// webserver.go. Each show is watched independently so only the show
+// that actually changed gets its feed regenerated.
 func watch() {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer watcher.Close()
+	showWatcher = watcher
 
 	done := make(chan bool)
 
@@ -45,7 +168,7 @@ func watch() {
 			case event := <-watcher.Events:
 				// log.Println("event:", event)
 				if event.Op&fsnotify.Write == fsnotify.Write {
-					GenerateRss()
+					GenerateRss(showForPath(event.Name))
 				}
 			case err := <-watcher.Errors:
 				log.Println("error:", err)
@@ -53,21 +176,55 @@ func watch() {
 		}
 	}()
 
-	err = watcher.Add("podcasts/")
+	slugs, err := shows.List()
 	if err != nil {
 		log.Fatal(err)
 	}
-	err = watcher.Add("assets/config/config.json")
+	for _, slug := range slugs {
+		addShowWatch(slug)
+	}
+	<-done
+}
+
+// addShowWatch registers a show's podcasts/ directory and config.json
+// with the running watcher, so shows created after startup (via
+// router.OnShowCreated) get picked up without a restart.
+func addShowWatch(slug string) {
+	if showWatcher == nil {
+		return
+	}
+	if err := showWatcher.Add(shows.PodcastsDir(slug)); err != nil {
+		log.Println("error watching", slug, ":", err)
+	}
+	if err := showWatcher.Add(shows.ConfigPath(slug)); err != nil {
+		log.Println("error watching", slug, ":", err)
+	}
+}
+
+// showForPath recovers a show's slug from a path under shows/<slug>/...
+// as reported by fsnotify.
+func showForPath(path string) string {
+	rest := strings.TrimPrefix(path, shows.Root+"/")
+	parts := strings.SplitN(rest, "/", 2)
+	return parts[0]
+}
+
+// GenerateAllRss regenerates the feed for every hosted show.
+func GenerateAllRss() {
+	slugs, err := shows.List()
 	if err != nil {
 		log.Fatal(err)
 	}
-	<-done
+	for _, slug := range slugs {
+		GenerateRss(slug)
+	}
 }
 
-// Iterate through podcasts directory and build feed
-// object, then compile as json and rss and write to file 
-func GenerateRss() {
-	d, err := ioutil.ReadFile("assets/config/config.json")
+// GenerateRss iterates a show's podcasts directory and builds its feed
+// object, then compiles it as json and rss and writes both to the
+// show's directory.
+func GenerateRss(slug string) {
+	d, err := ioutil.ReadFile(shows.ConfigPath(slug))
 	if err != nil {
 		panic(err)
 	}
@@ -78,12 +235,15 @@ func GenerateRss() {
 	}
 
 	now := time.Now()
-	files, err := ioutil.ReadDir("podcasts")
+	files, err := ioutil.ReadDir(shows.PodcastsDir(slug))
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	podcasturl := config.PodcastUrl
+	known := loadKnownEpisodes(slug)
+	var newEpisodes []announcedEpisode
+
+	podcasturl := config.PodcastUrl + "/" + slug
 	feed := &feeds.Feed{
 		Title:       config.Name,
 		Link:        &feeds.Link{Href: podcasturl},
@@ -91,6 +251,19 @@ func GenerateRss() {
 		Author:      &feeds.Author{Name: config.Host, Email: config.Email},
 		Created:     now,
 		Image:       &feeds.Image{Url: config.Image},
+		Language:    config.Language,
+		Copyright:   config.Copyright,
+		AtomLink:    podcasturl + "/rss",
+		ITunes: &feeds.ITunesFeedExtension{
+			Subtitle:   config.Subtitle,
+			Summary:    config.Summary,
+			Author:     config.Host,
+			Owner:      &feeds.ITunesOwner{Name: config.OwnerName, Email: config.OwnerEmail},
+			Image:      config.Image,
+			Explicit:   config.Explicit,
+			Type:       config.Type,
+			Categories: itunesCategories(config.Categories),
+		},
 	}
 
 	for _, file := range files {
@@ -98,16 +271,20 @@ func GenerateRss() {
 			s := strings.Split(file.Name(), "_")
 			t := strings.Split(s[1], ".")
 			title := t[0]
-			description, err := ioutil.ReadFile("podcasts/" + strings.Replace(file.Name(), ".mp3", "_SHOWNOTES.md", 2))
+			description, err := ioutil.ReadFile(shows.PodcastsDir(slug) + "/" + strings.Replace(file.Name(), ".mp3", "_SHOWNOTES.md", 2))
 			if err != nil {
 				log.Fatal(err)
 			}
+			meta := readEpisodeMeta(shows.PodcastsDir(slug) + "/" + strings.Replace(file.Name(), ".mp3", "_META.json", 2))
 			date, err := time.Parse("2006-01-02", s[0])
 			if err != nil {
 				log.Fatal(err)
 			}
 			size := fmt.Sprintf("%d", file.Size())
 			link := podcasturl + "/download/" + file.Name()
+			if !known[file.Name()] {
+				newEpisodes = append(newEpisodes, announcedEpisode{name: file.Name(), title: title, link: link})
+			}
 			feed.Add(
 				&feeds.Item{
 					Title:       title,
@@ -116,6 +293,14 @@ func GenerateRss() {
 					Description: string(description),
 					Author:      &feeds.Author{Name: config.Host, Email: config.Email},
 					Created:     date,
+					ITunes: &feeds.ITunesItemExtension{
+						Subtitle:    meta.Subtitle,
+						Summary:     meta.Summary,
+						Duration:    meta.Duration,
+						Season:      meta.Season,
+						Episode:     meta.Episode,
+						EpisodeType: meta.EpisodeType,
+					},
 				},
 			)
 		}
@@ -135,8 +320,18 @@ func GenerateRss() {
 
 	// Write to files as neccesary
 	rss_byte := []byte(rss)
-	ioutil.WriteFile("assets/web/feed.rss", rss_byte, 0644)
+	ioutil.WriteFile(shows.Dir(slug)+"/feed.rss", rss_byte, 0644)
 
 	json_byte := []byte(json)
-	ioutil.WriteFile("assets/web/feed.json", json_byte, 0644)
+	ioutil.WriteFile(shows.Dir(slug)+"/feed.json", json_byte, 0644)
+
+	if config.EnableActivityPub {
+		for _, episode := range newEpisodes {
+			activitypub.Announce(episode.title, episode.link)
+		}
+	}
+	for _, episode := range newEpisodes {
+		known[episode.name] = true
+	}
+	saveKnownEpisodes(slug, known)
 }
@@ -0,0 +1,43 @@
+/* hash_password.go
+ *
+ * Implements the "hash-password" CLI subcommand: `pogo hash-password`
+ * prompts for a password and prints a bcrypt hash suitable for pasting
+ * into users.json, so operators never have to put a plaintext password
+ * on disk - or in their shell history - themselves.
+ */
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gmemstr/pogo/auth"
+)
+
+// RunHashPassword implements `pogo hash-password`, dispatched from
+// main() when os.Args[1] == "hash-password". The password is read from
+// stdin rather than taken as an argument so it doesn't end up in `ps`
+// output or shell history.
+func RunHashPassword(args []string) {
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error reading password:", err)
+		os.Exit(1)
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		fmt.Fprintln(os.Stderr, "password must not be empty")
+		os.Exit(1)
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error hashing password:", err)
+		os.Exit(1)
+	}
+
+	fmt.Println(hash)
+}
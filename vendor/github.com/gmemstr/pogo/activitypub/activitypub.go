@@ -0,0 +1,548 @@
+/* activitypub.go
+ *
+ * Optional federation subsystem. When enabled, the instance publishes
+ * its podcast as an ActivityPub actor, accepts Follow requests over
+ * /inbox, and announces new episodes to its followers. This is
+ * intentionally self-contained - no external federation library is
+ * pulled in, just enough of ActivityPub/HTTP Signatures to interop
+ * with Mastodon-style servers.
+ */
+package activitypub
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const keyPath = "assets/config/activitypub_key.pem"
+const followersPath = "assets/config/followers.json"
+
+// clockSkew bounds how far a signed request's Date header may drift
+// from wall-clock time, so a captured valid signature can't be
+// replayed indefinitely.
+const clockSkew = 5 * time.Minute
+
+// Actor describes a minimal ActivityPub Person/Service, enough for a
+// podcast host to be followable from Mastodon and friends.
+type Actor struct {
+	Context           []string  `json:"@context"`
+	ID                string    `json:"id"`
+	Type              string    `json:"type"`
+	PreferredUsername string    `json:"preferredUsername"`
+	Name              string    `json:"name"`
+	Summary           string    `json:"summary"`
+	Inbox             string    `json:"inbox"`
+	Outbox            string    `json:"outbox"`
+	PublicKey         PublicKey `json:"publicKey"`
+}
+
+type PublicKey struct {
+	ID           string `json:"id"`
+	Owner        string `json:"owner"`
+	PublicKeyPem string `json:"publicKeyPem"`
+}
+
+// Activity is a generic envelope, wide enough to cover the Follow,
+// Accept, Create and Announce activities this package sends/receives.
+type Activity struct {
+	Context string      `json:"@context"`
+	ID      string      `json:"id,omitempty"`
+	Type    string      `json:"type"`
+	Actor   string      `json:"actor"`
+	Object  interface{} `json:"object"`
+	To      []string    `json:"to,omitempty"`
+}
+
+// Config is the subset of the podcast config needed to build the
+// actor. It's filled in by router.Init from router.NewConfig.
+type Config struct {
+	Host              string // scheme+host the instance is served on, e.g. https://podcast.example
+	PreferredUsername string
+	Name              string
+	Summary           string
+}
+
+var cfg Config
+
+// Enable wires up the federation config and makes sure a keypair
+// exists on disk, generating one on first run.
+func Enable(c Config) error {
+	cfg = c
+	if _, err := loadPrivateKey(); err != nil {
+		return generateKeypair()
+	}
+	return nil
+}
+
+func actorIRI() string {
+	return cfg.Host + "/actor"
+}
+
+// ActorHandler serves the actor document at /actor.
+func ActorHandler(w http.ResponseWriter, r *http.Request) {
+	pub, err := loadPublicKeyPem()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	actor := Actor{
+		Context:           []string{"https://www.w3.org/ns/activitystreams", "https://w3id.org/security/v1"},
+		ID:                actorIRI(),
+		Type:              "Person",
+		PreferredUsername: cfg.PreferredUsername,
+		Name:              cfg.Name,
+		Summary:           cfg.Summary,
+		Inbox:             cfg.Host + "/inbox",
+		Outbox:            cfg.Host + "/outbox",
+		PublicKey: PublicKey{
+			ID:           actorIRI() + "#main-key",
+			Owner:        actorIRI(),
+			PublicKeyPem: pub,
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/activity+json")
+	json.NewEncoder(w).Encode(actor)
+}
+
+// WebfingerHandler answers /.well-known/webfinger?resource=acct:name@host
+// by pointing at the actor IRI.
+func WebfingerHandler(w http.ResponseWriter, r *http.Request) {
+	resource := r.URL.Query().Get("resource")
+	expected := fmt.Sprintf("acct:%s@%s", cfg.PreferredUsername, strings.TrimPrefix(strings.TrimPrefix(cfg.Host, "https://"), "http://"))
+	if resource != expected {
+		http.Error(w, "resource not found", http.StatusNotFound)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"subject": resource,
+		"links": []map[string]string{
+			{
+				"rel":  "self",
+				"type": "application/activity+json",
+				"href": actorIRI(),
+			},
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/jrd+json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// InboxHandler verifies an incoming activity's HTTP Signature, and
+// handles Follow by persisting the follower and replying with an
+// Accept, and Undo by removing the sender from the followers. Anything
+// else is acknowledged and dropped.
+func InboxHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "error reading body", http.StatusBadRequest)
+		return
+	}
+
+	var activity Activity
+	if err := json.Unmarshal(body, &activity); err != nil {
+		http.Error(w, "malformed activity", http.StatusBadRequest)
+		return
+	}
+
+	pubKeyPem, err := fetchActorPublicKey(activity.Actor)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not resolve actor key: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := verifyRequestSignature(r, body, pubKeyPem); err != nil {
+		http.Error(w, fmt.Sprintf("invalid signature: %v", err), http.StatusUnauthorized)
+		return
+	}
+
+	switch activity.Type {
+	case "Follow":
+		if err := addFollower(activity.Actor); err != nil {
+			log.Println("error persisting follower:", err)
+		}
+		if err := sendAccept(activity); err != nil {
+			log.Println("error replying with accept:", err)
+		}
+	case "Undo":
+		removeFollower(activity.Actor)
+	default:
+		// Nothing else to do with Like/Announce/etc. yet.
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Announce posts a Create activity wrapping a Note about a new episode
+// to every follower's inbox. Called from GenerateRss whenever a new
+// episode is published.
+func Announce(episodeTitle, episodeURL string) {
+	followers, err := loadFollowers()
+	if err != nil {
+		log.Println("error loading followers:", err)
+		return
+	}
+
+	note := map[string]interface{}{
+		"type":         "Note",
+		"attributedTo": actorIRI(),
+		"content":      fmt.Sprintf("New episode: %s\n\n%s", episodeTitle, episodeURL),
+		"published":    time.Now().UTC().Format(time.RFC3339),
+	}
+
+	activity := Activity{
+		Context: "https://www.w3.org/ns/activitystreams",
+		Type:    "Create",
+		Actor:   actorIRI(),
+		Object:  note,
+		To:      []string{"https://www.w3.org/ns/activitystreams#Public"},
+	}
+
+	for _, follower := range followers {
+		inbox, err := fetchActorInbox(follower)
+		if err != nil {
+			log.Println("error resolving inbox for", follower, ":", err)
+			continue
+		}
+		if err := deliver(inbox, activity); err != nil {
+			log.Println("error delivering to", inbox, ":", err)
+		}
+	}
+}
+
+func sendAccept(follow Activity) error {
+	inbox, err := fetchActorInbox(follow.Actor)
+	if err != nil {
+		return err
+	}
+
+	accept := Activity{
+		Context: "https://www.w3.org/ns/activitystreams",
+		Type:    "Accept",
+		Actor:   actorIRI(),
+		Object:  follow,
+	}
+
+	return deliver(inbox, accept)
+}
+
+func deliver(inbox string, activity Activity) error {
+	body, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", inbox, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/activity+json")
+
+	if err := signRequest(req, body); err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("delivery to %s failed: %s", inbox, resp.Status)
+	}
+	return nil
+}
+
+func fetchActorInbox(iri string) (string, error) {
+	var actor Actor
+	if err := fetchActor(iri, &actor); err != nil {
+		return "", err
+	}
+	return actor.Inbox, nil
+}
+
+func fetchActorPublicKey(iri string) (string, error) {
+	var actor Actor
+	if err := fetchActor(iri, &actor); err != nil {
+		return "", err
+	}
+	return actor.PublicKey.PublicKeyPem, nil
+}
+
+func fetchActor(iri string, out *Actor) error {
+	req, err := http.NewRequest("GET", iri, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/activity+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func addFollower(iri string) error {
+	followers, err := loadFollowers()
+	if err != nil {
+		followers = []string{}
+	}
+	for _, f := range followers {
+		if f == iri {
+			return nil
+		}
+	}
+	followers = append(followers, iri)
+	return saveFollowers(followers)
+}
+
+func removeFollower(iri string) {
+	followers, err := loadFollowers()
+	if err != nil {
+		return
+	}
+	var kept []string
+	for _, f := range followers {
+		if f != iri {
+			kept = append(kept, f)
+		}
+	}
+	saveFollowers(kept)
+}
+
+func loadFollowers() ([]string, error) {
+	d, err := ioutil.ReadFile(followersPath)
+	if err != nil {
+		return nil, err
+	}
+	var followers []string
+	if err := json.Unmarshal(d, &followers); err != nil {
+		return nil, err
+	}
+	return followers, nil
+}
+
+func saveFollowers(followers []string) error {
+	b, err := json.Marshal(followers)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(followersPath, b, 0644)
+}
+
+// ---- keys & HTTP Signatures (draft-cavage) ----
+
+func generateKeypair() error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+
+	block := &pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}
+	return ioutil.WriteFile(keyPath, pem.EncodeToMemory(block), 0600)
+}
+
+func loadPrivateKey() (*rsa.PrivateKey, error) {
+	d, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(d)
+	if block == nil {
+		return nil, fmt.Errorf("invalid PEM in %s", keyPath)
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+func loadPublicKeyPem() (string, error) {
+	key, err := loadPrivateKey()
+	if err != nil {
+		return "", err
+	}
+	b, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	block := &pem.Block{Type: "PUBLIC KEY", Bytes: b}
+	return string(pem.EncodeToMemory(block)), nil
+}
+
+func parsePublicKeyPem(pemStr string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(pemStr))
+	if block == nil {
+		return nil, fmt.Errorf("invalid PEM in remote actor public key")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaPub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("remote actor public key is not RSA")
+	}
+	return rsaPub, nil
+}
+
+// signRequest signs an outgoing request per draft-cavage, over
+// (request-target), host, date and digest.
+func signRequest(req *http.Request, body []byte) error {
+	key, err := loadPrivateKey()
+	if err != nil {
+		return err
+	}
+
+	digest := sha256.Sum256(body)
+	req.Header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(digest[:]))
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("Host", req.URL.Host)
+
+	signingString, headers := buildSigningString(req)
+
+	h := sha256.Sum256([]byte(signingString))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, h[:])
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Signature", fmt.Sprintf(
+		`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		actorIRI()+"#main-key", headers, base64.StdEncoding.EncodeToString(sig),
+	))
+	return nil
+}
+
+// verifyRequestSignature verifies an inbound request's Signature
+// header against the sender's public key.
+func verifyRequestSignature(r *http.Request, body []byte, pubKeyPem string) error {
+	sigHeader := r.Header.Get("Signature")
+	if sigHeader == "" {
+		return fmt.Errorf("missing Signature header")
+	}
+
+	params := parseSignatureHeader(sigHeader)
+	headersParam, ok := params["headers"]
+	if !ok {
+		return fmt.Errorf("missing headers param")
+	}
+	signature, ok := params["signature"]
+	if !ok {
+		return fmt.Errorf("missing signature param")
+	}
+
+	fields := strings.Fields(headersParam)
+	if !coversFields(fields, "(request-target)", "host", "date", "digest") {
+		return fmt.Errorf("signature must cover (request-target), host, date and digest, got %q", headersParam)
+	}
+
+	digest := r.Header.Get("Digest")
+	if digest == "" {
+		return fmt.Errorf("missing Digest header")
+	}
+	sum := sha256.Sum256(body)
+	expected := "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+	if digest != expected {
+		return fmt.Errorf("digest mismatch")
+	}
+
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil {
+		return fmt.Errorf("missing or unparseable Date header: %v", err)
+	}
+	if skew := time.Since(date); skew < -clockSkew || skew > clockSkew {
+		return fmt.Errorf("Date header %s is outside the allowed %s clock skew", date, clockSkew)
+	}
+
+	r.Header.Set("Host", r.Host)
+	signingString := buildVerifyString(r, fields)
+
+	pub, err := parsePublicKeyPem(pubKeyPem)
+	if err != nil {
+		return err
+	}
+
+	sigBytes, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return err
+	}
+
+	h := sha256.Sum256([]byte(signingString))
+	return rsa.VerifyPKCS1v15(pub, crypto.SHA256, h[:], sigBytes)
+}
+
+func buildSigningString(req *http.Request) (string, string) {
+	fields := []string{"(request-target)", "host", "date", "digest"}
+	var lines []string
+	for _, f := range fields {
+		if f == "(request-target)" {
+			lines = append(lines, fmt.Sprintf("(request-target): %s %s", strings.ToLower(req.Method), req.URL.RequestURI()))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", f, req.Header.Get(f)))
+	}
+	return strings.Join(lines, "\n"), strings.Join(fields, " ")
+}
+
+func buildVerifyString(r *http.Request, fields []string) string {
+	var lines []string
+	for _, f := range fields {
+		if f == "(request-target)" {
+			lines = append(lines, fmt.Sprintf("(request-target): %s %s", strings.ToLower(r.Method), r.URL.RequestURI()))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", f, r.Header.Get(f)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// coversFields reports whether every one of required appears in fields,
+// so verifyRequestSignature can refuse a signature that doesn't commit
+// to binding itself to the request it's attached to.
+func coversFields(fields []string, required ...string) bool {
+	have := map[string]bool{}
+	for _, f := range fields {
+		have[f] = true
+	}
+	for _, r := range required {
+		if !have[r] {
+			return false
+		}
+	}
+	return true
+}
+
+func parseSignatureHeader(header string) map[string]string {
+	params := map[string]string{}
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(kv[0])
+		value := strings.Trim(strings.TrimSpace(kv[1]), `"`)
+		params[key] = value
+	}
+	return params
+}
@@ -0,0 +1,117 @@
+/* admin.go
+ *
+ * Handlers behind the /admin/{show}/... routes: publishing a new
+ * episode, deleting one, and setting a show's custom CSS.
+ */
+package admin
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gorilla/mux"
+	"github.com/gmemstr/pogo/common"
+	"github.com/gmemstr/pogo/shows"
+)
+
+// CreateEpisode handles a multipart upload of an mp3 and its
+// shownotes, writing both into the show's podcasts/ directory using
+// the "<date>_<title>" naming GenerateRss expects.
+func CreateEpisode() common.Handler {
+	return func(rc *common.RouterContext, w http.ResponseWriter, r *http.Request) *common.HTTPError {
+		slug := mux.Vars(r)["show"]
+		if !shows.Exists(slug) {
+			return &common.HTTPError{Message: "unknown show: " + slug, StatusCode: http.StatusNotFound}
+		}
+
+		if err := r.ParseMultipartForm(64 << 20); err != nil {
+			return &common.HTTPError{Message: err.Error(), StatusCode: http.StatusBadRequest}
+		}
+
+		date := r.Form.Get("date")
+		title := r.Form.Get("title")
+		if date == "" || title == "" {
+			return &common.HTTPError{Message: "date and title are required", StatusCode: http.StatusBadRequest}
+		}
+		// filepath.Base each field independently - applying it to the
+		// already-joined string would let a "/" in title collapse the
+		// whole basename to whatever follows its last slash, dropping
+		// the date and the "_" separator GenerateRss splits episode
+		// filenames on.
+		basename := filepath.Base(date) + "_" + filepath.Base(title)
+
+		file, _, err := r.FormFile("episode")
+		if err != nil {
+			return &common.HTTPError{Message: "missing episode file: " + err.Error(), StatusCode: http.StatusBadRequest}
+		}
+		defer file.Close()
+
+		out, err := os.Create(shows.PodcastsDir(slug) + "/" + basename + ".mp3")
+		if err != nil {
+			return &common.HTTPError{Message: err.Error(), StatusCode: http.StatusInternalServerError}
+		}
+		defer out.Close()
+		if _, err := io.Copy(out, file); err != nil {
+			return &common.HTTPError{Message: err.Error(), StatusCode: http.StatusInternalServerError}
+		}
+
+		shownotes := r.Form.Get("shownotes")
+		notesPath := shows.PodcastsDir(slug) + "/" + basename + "_SHOWNOTES.md"
+		if err := ioutil.WriteFile(notesPath, []byte(shownotes), 0644); err != nil {
+			return &common.HTTPError{Message: err.Error(), StatusCode: http.StatusInternalServerError}
+		}
+
+		w.Write([]byte(fmt.Sprintf("published %s for %s", basename, slug)))
+		return nil
+	}
+}
+
+// RemoveEpisode deletes an episode's mp3 and shownotes from a show.
+func RemoveEpisode() common.Handler {
+	return func(rc *common.RouterContext, w http.ResponseWriter, r *http.Request) *common.HTTPError {
+		slug := mux.Vars(r)["show"]
+		episode := r.URL.Query().Get("episode")
+		if episode == "" {
+			return &common.HTTPError{Message: "episode is required", StatusCode: http.StatusBadRequest}
+		}
+		// filepath.Base keeps episode from escaping this show's
+		// podcasts/ directory via a "../" component, same as api.DeleteEpisode.
+		episode = filepath.Base(episode)
+
+		base := shows.PodcastsDir(slug) + "/" + episode
+		os.Remove(base + ".mp3")
+		os.Remove(base + "_SHOWNOTES.md")
+		os.Remove(base + "_META.json")
+
+		w.Write([]byte("deleted"))
+		return nil
+	}
+}
+
+// CustomCss reads or updates the custom.css file in a show's
+// directory.
+func CustomCss() common.Handler {
+	return func(rc *common.RouterContext, w http.ResponseWriter, r *http.Request) *common.HTTPError {
+		slug := mux.Vars(r)["show"]
+		path := shows.Dir(slug) + "/custom.css"
+
+		if r.Method == "GET" {
+			w.Header().Set("Content-Type", "text/css")
+			return common.ReadAndServeFile(path, w)
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return &common.HTTPError{Message: err.Error(), StatusCode: http.StatusBadRequest}
+		}
+		if err := ioutil.WriteFile(path, body, 0644); err != nil {
+			return &common.HTTPError{Message: err.Error(), StatusCode: http.StatusInternalServerError}
+		}
+		w.Write([]byte("saved"))
+		return nil
+	}
+}
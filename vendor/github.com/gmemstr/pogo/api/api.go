@@ -0,0 +1,206 @@
+/* api.go
+ *
+ * The JSON counterpart to the HTML admin surface, versioned under
+ * /api/v1/ so mobile uploaders, CI publishers and other third-party
+ * clients have something stable to drive a pogo instance with instead
+ * of scraping the admin forms. Every show-scoped endpoint mirrors the
+ * admin package's behaviour, just speaking JSON in and out.
+ */
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/gmemstr/pogo/apiutil"
+	"github.com/gmemstr/pogo/auth"
+	"github.com/gmemstr/pogo/common"
+	"github.com/gmemstr/pogo/shows"
+)
+
+// Episode is what a podcast episode looks like over the API.
+type Episode struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Date  string `json:"date"`
+}
+
+// Login exchanges a username/password for a bearer token, the API
+// equivalent of the cookie session the HTML admin UI gets.
+func Login() common.Handler {
+	return func(rc *common.RouterContext, w http.ResponseWriter, r *http.Request) *common.HTTPError {
+		var body struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return &common.HTTPError{Message: "malformed request body", StatusCode: http.StatusBadRequest}
+		}
+
+		d, err := ioutil.ReadFile("assets/config/users.json")
+		if err != nil {
+			return &common.HTTPError{Message: err.Error(), StatusCode: http.StatusInternalServerError}
+		}
+		var users map[string]auth.UserRecord
+		if err := json.Unmarshal(d, &users); err != nil {
+			return &common.HTTPError{Message: err.Error(), StatusCode: http.StatusInternalServerError}
+		}
+
+		record, ok := users[body.Username]
+		if !ok || !auth.CheckPassword(record.Password, body.Password) {
+			return &common.HTTPError{Message: "invalid credentials", StatusCode: http.StatusUnauthorized}
+		}
+
+		token, err := auth.CreateToken(&common.User{Username: body.Username})
+		if err != nil {
+			return &common.HTTPError{Message: err.Error(), StatusCode: http.StatusInternalServerError}
+		}
+
+		apiutil.WriteJSON(w, http.StatusOK, map[string]string{"token": token})
+		return nil
+	}
+}
+
+// ListEpisodes returns every episode of a show.
+func ListEpisodes() common.Handler {
+	return func(rc *common.RouterContext, w http.ResponseWriter, r *http.Request) *common.HTTPError {
+		slug := mux.Vars(r)["show"]
+
+		files, err := ioutil.ReadDir(shows.PodcastsDir(slug))
+		if err != nil {
+			return &common.HTTPError{Message: err.Error(), StatusCode: http.StatusNotFound}
+		}
+
+		var episodes []Episode
+		for _, file := range files {
+			if !strings.HasSuffix(file.Name(), ".mp3") {
+				continue
+			}
+			id := strings.TrimSuffix(file.Name(), ".mp3")
+			parts := strings.SplitN(id, "_", 2)
+			episode := Episode{ID: id}
+			if len(parts) == 2 {
+				episode.Date = parts[0]
+				episode.Title = parts[1]
+			}
+			episodes = append(episodes, episode)
+		}
+
+		apiutil.WriteJSON(w, http.StatusOK, episodes)
+		return nil
+	}
+}
+
+// CreateEpisode is admin.CreateEpisode's JSON counterpart: a
+// multipart upload that responds with the created episode.
+func CreateEpisode() common.Handler {
+	return func(rc *common.RouterContext, w http.ResponseWriter, r *http.Request) *common.HTTPError {
+		slug := mux.Vars(r)["show"]
+		if !shows.Exists(slug) {
+			return &common.HTTPError{Message: "unknown show: " + slug, StatusCode: http.StatusNotFound}
+		}
+
+		if err := r.ParseMultipartForm(64 << 20); err != nil {
+			return &common.HTTPError{Message: err.Error(), StatusCode: http.StatusBadRequest}
+		}
+
+		date := r.Form.Get("date")
+		title := r.Form.Get("title")
+		if date == "" || title == "" {
+			return &common.HTTPError{Message: "date and title are required", StatusCode: http.StatusBadRequest}
+		}
+		// Base each field independently - see admin.CreateEpisode for
+		// why basing the joined string isn't enough.
+		id := filepath.Base(date) + "_" + filepath.Base(title)
+
+		file, _, err := r.FormFile("episode")
+		if err != nil {
+			return &common.HTTPError{Message: "missing episode file: " + err.Error(), StatusCode: http.StatusBadRequest}
+		}
+		defer file.Close()
+
+		out, err := os.Create(shows.PodcastsDir(slug) + "/" + id + ".mp3")
+		if err != nil {
+			return &common.HTTPError{Message: err.Error(), StatusCode: http.StatusInternalServerError}
+		}
+		defer out.Close()
+		if _, err := io.Copy(out, file); err != nil {
+			return &common.HTTPError{Message: err.Error(), StatusCode: http.StatusInternalServerError}
+		}
+
+		shownotes := r.Form.Get("shownotes")
+		notesPath := shows.PodcastsDir(slug) + "/" + id + "_SHOWNOTES.md"
+		if err := ioutil.WriteFile(notesPath, []byte(shownotes), 0644); err != nil {
+			return &common.HTTPError{Message: err.Error(), StatusCode: http.StatusInternalServerError}
+		}
+
+		apiutil.WriteJSON(w, http.StatusCreated, Episode{ID: id, Title: title, Date: date})
+		return nil
+	}
+}
+
+// DeleteEpisode removes an episode by ID (its "<date>_<title>" stem).
+func DeleteEpisode() common.Handler {
+	return func(rc *common.RouterContext, w http.ResponseWriter, r *http.Request) *common.HTTPError {
+		slug := mux.Vars(r)["show"]
+		id := filepath.Base(mux.Vars(r)["id"])
+
+		base := shows.PodcastsDir(slug) + "/" + id
+		if _, err := os.Stat(base + ".mp3"); err != nil {
+			return &common.HTTPError{Message: "episode not found", StatusCode: http.StatusNotFound}
+		}
+
+		os.Remove(base + ".mp3")
+		os.Remove(base + "_SHOWNOTES.md")
+		os.Remove(base + "_META.json")
+
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
+
+// GetConfig returns a show's config.json verbatim.
+func GetConfig() common.Handler {
+	return func(rc *common.RouterContext, w http.ResponseWriter, r *http.Request) *common.HTTPError {
+		slug := mux.Vars(r)["show"]
+
+		d, err := ioutil.ReadFile(shows.ConfigPath(slug))
+		if err != nil {
+			return &common.HTTPError{Message: "show not found", StatusCode: http.StatusNotFound}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(d)
+		return nil
+	}
+}
+
+// PutConfig overwrites a show's config.json with the request body.
+func PutConfig() common.Handler {
+	return func(rc *common.RouterContext, w http.ResponseWriter, r *http.Request) *common.HTTPError {
+		slug := mux.Vars(r)["show"]
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return &common.HTTPError{Message: err.Error(), StatusCode: http.StatusBadRequest}
+		}
+
+		var probe map[string]interface{}
+		if err := json.Unmarshal(body, &probe); err != nil {
+			return &common.HTTPError{Message: "body must be valid JSON", StatusCode: http.StatusBadRequest}
+		}
+
+		if err := ioutil.WriteFile(shows.ConfigPath(slug), body, 0644); err != nil {
+			return &common.HTTPError{Message: err.Error(), StatusCode: http.StatusInternalServerError}
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
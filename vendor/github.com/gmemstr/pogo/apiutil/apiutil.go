@@ -0,0 +1,35 @@
+/* apiutil.go
+ *
+ * Consistent JSON envelopes for the /api/v1/ surface, so every
+ * endpoint errors and succeeds the same shape instead of each handler
+ * improvising its own response body.
+ */
+package apiutil
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gmemstr/pogo/common"
+)
+
+// ErrorEnvelope is the body written for any *common.HTTPError
+// returned from an /api/v1/ handler.
+type ErrorEnvelope struct {
+	Error string `json:"error"`
+}
+
+// WriteError renders a common.HTTPError as a JSON error envelope.
+func WriteError(w http.ResponseWriter, err *common.HTTPError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(err.StatusCode)
+	json.NewEncoder(w).Encode(ErrorEnvelope{Error: err.Message})
+}
+
+// WriteJSON writes v as a JSON response body with the given status
+// code.
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
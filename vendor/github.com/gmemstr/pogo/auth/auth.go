@@ -0,0 +1,389 @@
+/* auth.go
+ *
+ * Server-side sessions backed by BoltDB. CreateSession stores a User
+ * under a random session ID and sets an opaque, HMAC-signed cookie
+ * pointing at it; DecryptCookie looks the session back up, and
+ * RequireAuthorization is the middleware that gates /admin routes on
+ * having a valid one. Signing keys rotate periodically so old cookies
+ * age out even if the store entry is still around.
+ */
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	bolt "go.etcd.io/bbolt"
+	"github.com/gmemstr/pogo/common"
+)
+
+const cookieName = "pogo_session"
+const usersPath = "assets/config/users.json"
+const dbPath = "assets/config/sessions.db"
+const sessionsBucket = "sessions"
+const sessionTTL = 30 * 24 * time.Hour
+
+// keyRotationInterval controls how often a new signing key is put
+// into use; the previous key is kept around just long enough to
+// validate cookies issued under it.
+const keyRotationInterval = 24 * time.Hour
+
+type session struct {
+	User      common.User `json:"user"`
+	ExpiresAt time.Time   `json:"expiresAt"`
+}
+
+// UserRecord is one entry of users.json: a user's bcrypt password hash
+// plus the slugs of the shows they're allowed to administer.
+type UserRecord struct {
+	Password string   `json:"password"`
+	Shows    []string `json:"shows"`
+}
+
+// CanAccessShow reports whether username is permitted to administer
+// the show identified by slug, per users.json.
+func CanAccessShow(username, slug string) bool {
+	users, err := loadUsers()
+	if err != nil {
+		return false
+	}
+
+	record, ok := users[username]
+	if !ok {
+		return false
+	}
+	for _, show := range record.Shows {
+		if show == slug {
+			return true
+		}
+	}
+	return false
+}
+
+// GrantShowAccess adds slug to username's list of administrable shows
+// in users.json, so a user who just created a show can immediately
+// pass CanAccessShow for it. A no-op if they already can.
+func GrantShowAccess(username, slug string) error {
+	users, err := loadUsers()
+	if err != nil {
+		return err
+	}
+
+	record, ok := users[username]
+	if !ok {
+		return fmt.Errorf("unknown user: %s", username)
+	}
+	for _, show := range record.Shows {
+		if show == slug {
+			return nil
+		}
+	}
+	record.Shows = append(record.Shows, slug)
+	users[username] = record
+
+	b, err := json.Marshal(users)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(usersPath, b, 0644)
+}
+
+func loadUsers() (map[string]UserRecord, error) {
+	d, err := ioutil.ReadFile(usersPath)
+	if err != nil {
+		return nil, err
+	}
+	var users map[string]UserRecord
+	err = json.Unmarshal(d, &users)
+	return users, err
+}
+
+func db() (*bolt.DB, error) {
+	return bolt.Open(dbPath, 0600, &bolt.Options{Timeout: 5 * time.Second})
+}
+
+// CreateSession stores a User server-side and returns the signed
+// cookie pointing at it, plus the CSRF token to hand back to the
+// client for use on subsequent /admin/{show}/... POSTs.
+func CreateSession(user *common.User) (*http.Cookie, string, error) {
+	d, err := db()
+	if err != nil {
+		return nil, "", err
+	}
+	defer d.Close()
+
+	id := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return nil, "", err
+	}
+	sessionID := base64.URLEncoding.EncodeToString(id)
+
+	s := session{User: *user, ExpiresAt: time.Now().Add(sessionTTL)}
+	value, err := json.Marshal(s)
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = d.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(sessionsBucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(sessionID), value)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	csrfToken, err := CSRFToken(sessionID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	signed, err := signSessionID(sessionID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &http.Cookie{
+		Name:     cookieName,
+		Value:    signed,
+		Path:     "/",
+		HttpOnly: true,
+		Expires:  s.ExpiresAt,
+	}, csrfToken, nil
+}
+
+// DecryptCookie validates the session cookie on r and returns the User
+// it was issued for. The name is kept from the hand-rolled-cookie era
+// so call sites didn't need to change when sessions moved server-side.
+func DecryptCookie(r *http.Request) (*common.User, error) {
+	c, err := r.Cookie(cookieName)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionID, err := verifySessionID(c.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := db()
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	var s session
+	err = d.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(sessionsBucket))
+		if b == nil {
+			return errors.New("no sessions have been created yet")
+		}
+		value := b.Get([]byte(sessionID))
+		if value == nil {
+			return errors.New("session not found")
+		}
+		return json.Unmarshal(value, &s)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(s.ExpiresAt) {
+		return nil, errors.New("session expired")
+	}
+
+	return &s.User, nil
+}
+
+// CreateToken is CreateSession for API clients: it stores the same
+// kind of session, but hands back the signed value directly instead
+// of wrapping it in a cookie, for use as a Bearer token.
+func CreateToken(user *common.User) (string, error) {
+	cookie, _, err := CreateSession(user)
+	if err != nil {
+		return "", err
+	}
+	return cookie.Value, nil
+}
+
+// ValidateBearerToken is DecryptCookie for the Authorization: Bearer
+// header API clients send instead of a cookie.
+func ValidateBearerToken(r *http.Request) (*common.User, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, errors.New("missing bearer token")
+	}
+	token := strings.TrimPrefix(header, "Bearer ")
+
+	sessionID, err := verifySessionID(token)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := db()
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	var s session
+	err = d.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(sessionsBucket))
+		if b == nil {
+			return errors.New("no sessions have been created yet")
+		}
+		value := b.Get([]byte(sessionID))
+		if value == nil {
+			return errors.New("token not found")
+		}
+		return json.Unmarshal(value, &s)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(s.ExpiresAt) {
+		return nil, errors.New("token expired")
+	}
+
+	return &s.User, nil
+}
+
+// Logout invalidates the session named by r's cookie, if any.
+func Logout(r *http.Request) error {
+	c, err := r.Cookie(cookieName)
+	if err != nil {
+		return nil // nothing to log out of
+	}
+
+	sessionID, err := verifySessionID(c.Value)
+	if err != nil {
+		return nil
+	}
+
+	d, err := db()
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(sessionsBucket))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(sessionID))
+	})
+}
+
+// signSessionID HMACs a session ID with the current signing key so a
+// cookie value can't be forged or substituted for another session.
+func signSessionID(sessionID string) (string, error) {
+	key, err := currentSigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(sessionID))
+	sig := base64.URLEncoding.EncodeToString(mac.Sum(nil))
+
+	return sessionID + "." + sig, nil
+}
+
+// verifySessionID checks a cookie value's signature against every key
+// still within its rotation grace period, so a cookie signed just
+// before a rotation remains valid.
+func verifySessionID(cookieValue string) (string, error) {
+	sep := len(cookieValue) - 1
+	for sep >= 0 && cookieValue[sep] != '.' {
+		sep--
+	}
+	if sep < 0 {
+		return "", errors.New("malformed session cookie")
+	}
+	sessionID, sig := cookieValue[:sep], cookieValue[sep+1:]
+
+	wantSig, err := base64.URLEncoding.DecodeString(sig)
+	if err != nil {
+		return "", errors.New("malformed session cookie")
+	}
+
+	keys, err := signingKeys()
+	if err != nil {
+		return "", err
+	}
+
+	for _, key := range keys {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(sessionID))
+		if hmac.Equal(mac.Sum(nil), wantSig) {
+			return sessionID, nil
+		}
+	}
+	return "", errors.New("invalid session signature")
+}
+
+// RequireAuthorization is a common.Handler that rejects the request
+// unless it carries a valid session cookie, stashing the User on the
+// RouterContext for downstream handlers. When the route has a {show}
+// variable, the session's user must also be permitted to administer
+// that show.
+func RequireAuthorization() common.Handler {
+	return func(rc *common.RouterContext, w http.ResponseWriter, r *http.Request) *common.HTTPError {
+		user, err := DecryptCookie(r)
+		if err != nil {
+			return &common.HTTPError{
+				Message:    "not authorized: " + err.Error(),
+				StatusCode: http.StatusUnauthorized,
+			}
+		}
+
+		if slug, ok := mux.Vars(r)["show"]; ok && !CanAccessShow(user.Username, slug) {
+			return &common.HTTPError{
+				Message:    user.Username + " may not administer " + slug,
+				StatusCode: http.StatusForbidden,
+			}
+		}
+
+		rc.User = user
+		return nil
+	}
+}
+
+// RequireAPIAuthorization is RequireAuthorization for /api/v1/ routes,
+// which authenticate with a Bearer token instead of a session cookie.
+func RequireAPIAuthorization() common.Handler {
+	return func(rc *common.RouterContext, w http.ResponseWriter, r *http.Request) *common.HTTPError {
+		user, err := ValidateBearerToken(r)
+		if err != nil {
+			return &common.HTTPError{
+				Message:    "not authorized: " + err.Error(),
+				StatusCode: http.StatusUnauthorized,
+			}
+		}
+
+		if slug, ok := mux.Vars(r)["show"]; ok && !CanAccessShow(user.Username, slug) {
+			return &common.HTTPError{
+				Message:    user.Username + " may not administer " + slug,
+				StatusCode: http.StatusForbidden,
+			}
+		}
+
+		rc.User = user
+		return nil
+	}
+}
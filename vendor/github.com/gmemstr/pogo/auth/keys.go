@@ -0,0 +1,99 @@
+/* keys.go
+ *
+ * Signing keys used to HMAC session cookies. Keys rotate on a fixed
+ * interval; signSessionID always uses the newest one, while
+ * verifySessionID accepts every key still younger than sessionTTL, so
+ * a cookie's signature stays verifiable for as long as the session
+ * itself is valid.
+ */
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+const keysPath = "assets/config/session_keys.json"
+
+type signingKey struct {
+	Key       []byte    `json:"key"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// currentSigningKey returns the most recently created key, rotating
+// in a fresh one if the newest on disk is older than
+// keyRotationInterval.
+func currentSigningKey() ([]byte, error) {
+	keys, err := loadSigningKeys()
+	if err != nil {
+		return nil, err
+	}
+	return keys[0].Key, nil
+}
+
+// signingKeys returns every key still valid for verifying a cookie,
+// newest first.
+func signingKeys() ([][]byte, error) {
+	keys, err := loadSigningKeys()
+	if err != nil {
+		return nil, err
+	}
+	out := make([][]byte, len(keys))
+	for i, k := range keys {
+		out[i] = k.Key
+	}
+	return out, nil
+}
+
+// loadSigningKeys reads the keys on disk, rotating in a new one (and
+// dropping any older than sessionTTL plus one rotation interval) as
+// needed.
+func loadSigningKeys() ([]signingKey, error) {
+	var keys []signingKey
+	if d, err := ioutil.ReadFile(keysPath); err == nil {
+		json.Unmarshal(d, &keys)
+	}
+
+	if len(keys) == 0 || time.Since(keys[0].CreatedAt) > keyRotationInterval {
+		fresh, err := newSigningKey()
+		if err != nil {
+			return nil, err
+		}
+		keys = append([]signingKey{fresh}, keys...)
+	}
+
+	// Keep every key that could still be verifying a live session's
+	// cookie: a session can live up to sessionTTL, so its signing key
+	// has to stay around for just as long, not just one rotation. A key
+	// signs new sessions for up to keyRotationInterval after it is
+	// created, so it is kept that much longer than sessionTTL.
+	cutoff := time.Now().Add(-(sessionTTL + keyRotationInterval))
+	kept := []signingKey{keys[0]} // always keep the newest, even if clocks are off
+	for _, k := range keys[1:] {
+		if k.CreatedAt.After(cutoff) {
+			kept = append(kept, k)
+		}
+	}
+	keys = kept
+
+	b, err := json.Marshal(keys)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(keysPath, b, 0600); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func newSigningKey() (signingKey, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return signingKey{}, err
+	}
+	return signingKey{Key: key, CreatedAt: time.Now()}, nil
+}
@@ -0,0 +1,90 @@
+/* passwords.go
+ *
+ * bcrypt password hashing for users.json. MigrateUsers runs once at
+ * startup to hash any plaintext passwords left over from before pogo
+ * switched off of `v == password` comparisons.
+ */
+package auth
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// HashPassword bcrypt-hashes a plaintext password for storage in
+// users.json.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// CheckPassword reports whether password matches the bcrypt hash
+// stored for a user.
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// MigrateUsers hashes any plaintext passwords remaining in
+// users.json, also converting the legacy map[string]string layout
+// (username to plaintext password, predating per-show permissions)
+// into UserRecord along the way. It's a no-op once the file is already
+// in the current shape with every password bcrypt-hashed, so it's
+// safe to call on every startup.
+func MigrateUsers() error {
+	d, err := ioutil.ReadFile(usersPath)
+	if err != nil {
+		return err
+	}
+
+	var users map[string]UserRecord
+	rewrite := false
+	if err := json.Unmarshal(d, &users); err != nil {
+		var legacy map[string]string
+		if legacyErr := json.Unmarshal(d, &legacy); legacyErr != nil {
+			return err
+		}
+		users = make(map[string]UserRecord, len(legacy))
+		for username, password := range legacy {
+			users[username] = UserRecord{Password: password}
+		}
+		rewrite = true
+		log.Println("auth: migrating users.json from the legacy username/password layout")
+	}
+
+	for username, record := range users {
+		if looksLikeBcryptHash(record.Password) {
+			continue
+		}
+		hash, err := HashPassword(record.Password)
+		if err != nil {
+			return err
+		}
+		record.Password = hash
+		users[username] = record
+		rewrite = true
+		log.Printf("auth: migrated plaintext password for %s to bcrypt", username)
+	}
+
+	if !rewrite {
+		return nil
+	}
+
+	b, err := json.Marshal(users)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(usersPath, b, 0644)
+}
+
+// looksLikeBcryptHash checks for the standard bcrypt prefix, good
+// enough to tell a hash from a plaintext password during migration.
+func looksLikeBcryptHash(password string) bool {
+	return len(password) == 60 &&
+		(strings.HasPrefix(password, "$2a$") ||
+			strings.HasPrefix(password, "$2b$") ||
+			strings.HasPrefix(password, "$2y$"))
+}
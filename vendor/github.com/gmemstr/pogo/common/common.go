@@ -0,0 +1,52 @@
+/* common.go
+ *
+ * Shared types used to wire together the small middleware chain that
+ * router.Handle runs: a Handler gets a RouterContext to stash
+ * per-request state in, and returns an HTTPError instead of writing
+ * one directly so router.Handle can log and render it consistently.
+ */
+package common
+
+import (
+	"io/ioutil"
+	"net/http"
+)
+
+// User identifies the logged-in operator for the lifetime of a request.
+type User struct {
+	Username string
+}
+
+// RouterContext is threaded through a Handle() chain so handlers can
+// pass state (e.g. the authenticated User) to the next one.
+type RouterContext struct {
+	User *User
+}
+
+// HTTPError is returned by a Handler instead of writing the response
+// directly, so router.Handle can log it and render a consistent body.
+type HTTPError struct {
+	Message    string
+	StatusCode int
+}
+
+func (e *HTTPError) Error() string {
+	return e.Message
+}
+
+// Handler is the building block of a router.Handle(...) chain.
+type Handler func(rc *RouterContext, w http.ResponseWriter, r *http.Request) *HTTPError
+
+// ReadAndServeFile reads a file off disk and writes it to w, wrapping
+// any read error as an HTTPError.
+func ReadAndServeFile(path string, w http.ResponseWriter) *HTTPError {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return &HTTPError{
+			Message:    "could not read " + path + ": " + err.Error(),
+			StatusCode: http.StatusNotFound,
+		}
+	}
+	w.Write(d)
+	return nil
+}
@@ -6,14 +6,30 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
+	"regexp"
 	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/gmemstr/pogo/activitypub"
 	"github.com/gmemstr/pogo/admin"
+	"github.com/gmemstr/pogo/api"
+	"github.com/gmemstr/pogo/apiutil"
 	"github.com/gmemstr/pogo/auth"
 	"github.com/gmemstr/pogo/common"
+	"github.com/gmemstr/pogo/shows"
 )
 
+// slugPattern constrains a show's slug to what's safe to drop straight
+// into a shows/<slug> filesystem path - no "/" or ".." allowed.
+var slugPattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// OnShowCreated, if set, is called with a new show's slug right after
+// serveSetup finishes writing its config.json. main wires this up to
+// generate the show's first feed and add it to the fsnotify watcher,
+// without router having to import back into package main.
+var OnShowCreated func(slug string)
+
 type NewConfig struct {
 	Name        string
 	Host        string
@@ -21,10 +37,32 @@ type NewConfig struct {
 	Description string
 	Image       string
 	PodcastURL  string
+
+	Subtitle   string
+	Summary    string
+	OwnerName  string
+	OwnerEmail string
+	Explicit   bool
+	Type       string
+	Language   string
+	Copyright  string
+	Categories []Category
+
+	// EnableActivityPub turns the instance into a followable
+	// ActivityPub actor, see activitypub package.
+	EnableActivityPub     bool
+	ActivityPubUsername   string
+}
+
+type Category struct {
+	Name        string `json:"name"`
+	Subcategory string `json:"subcategory,omitempty"`
 }
 
 // Handle takes multiple Handler and executes them in a serial order starting from first to last.
 // In case, Any middle ware returns an error, The error is logged to console and sent to the user, Middlewares further up in chain are not executed.
+// Errors from a handler under /api/ are rendered as a JSON envelope;
+// everything else keeps the plain-text body HTML clients expect.
 func Handle(handlers ...common.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
@@ -34,7 +72,12 @@ func Handle(handlers ...common.Handler) http.Handler {
 			if err != nil {
 				log.Printf("%v", err)
 
-				w.Write([]byte(http.StatusText(err.StatusCode)))
+				if strings.HasPrefix(r.URL.Path, "/api/") {
+					apiutil.WriteError(w, err)
+				} else {
+					w.WriteHeader(err.StatusCode)
+					w.Write([]byte(http.StatusText(err.StatusCode)))
+				}
 
 				return
 			}
@@ -44,58 +87,162 @@ func Handle(handlers ...common.Handler) http.Handler {
 
 func Init() *mux.Router {
 
+	if err := auth.MigrateUsers(); err != nil {
+		log.Printf("could not migrate users.json to bcrypt: %v", err)
+	}
+
 	r := mux.NewRouter()
 
 	// "Static" paths
 	r.PathPrefix("/assets/").Handler(http.StripPrefix("/assets/", http.FileServer(http.Dir("assets/web/static"))))
-	r.PathPrefix("/download/").Handler(http.StripPrefix("/download/", http.FileServer(http.Dir("podcasts"))))
+	r.PathPrefix("/{show}/download/").HandlerFunc(downloadHandler)
+
+	registerActivityPub(r)
 
 	// Paths that require specific handlers
-	r.Handle("/", Handle(
+	r.Handle("/{show}/", Handle(
 		rootHandler(),
 	)).Methods("GET")
 
-	r.Handle("/rss", Handle(
+	r.Handle("/{show}/rss", Handle(
 		rootHandler(),
 	)).Methods("GET")
 
-	r.Handle("/json", Handle(
+	r.Handle("/{show}/json", Handle(
 		rootHandler(),
 	)).Methods("GET")
 
-	// Authenticated endpoints should be passed to BasicAuth()
+	// Authenticated endpoints should be passed to RequireAuthorization()
 	// first
 	r.Handle("/admin", Handle(
 		auth.RequireAuthorization(),
 		adminHandler(),
 	)).Methods("GET", "POST")
 
+	r.Handle("/admin/{show}", Handle(
+		auth.RequireAuthorization(),
+		adminHandler(),
+	)).Methods("GET", "POST")
+
 	r.Handle("/login", Handle(
 		loginHandler(),
 	)).Methods("GET", "POST")
 
-	r.Handle("/admin/publish", Handle(
+	r.Handle("/logout", Handle(
+		logoutHandler(),
+	)).Methods("POST")
+
+	r.Handle("/admin/{show}/publish", Handle(
 		auth.RequireAuthorization(),
+		auth.CSRFProtect(),
 		admin.CreateEpisode(),
 	)).Methods("POST")
 
-	r.Handle("/admin/delete", Handle(
+	r.Handle("/admin/{show}/delete", Handle(
 		auth.RequireAuthorization(),
+		auth.CSRFProtect(),
 		admin.RemoveEpisode(),
-	)).Methods("GET")
+	)).Methods("POST")
 
-	r.Handle("/admin/css", Handle(
+	r.Handle("/admin/{show}/css", Handle(
 		auth.RequireAuthorization(),
+		auth.CSRFProtect(),
 		admin.CustomCss(),
-	)).Methods("GET", "POST")
+	)).Methods("POST")
+
+	r.Handle("/admin/{show}/css", Handle(
+		auth.RequireAuthorization(),
+		admin.CustomCss(),
+	)).Methods("GET")
 
 	r.Handle("/setup", Handle(
+		auth.RequireAuthorization(),
 		serveSetup(),
 	)).Methods("GET", "POST")
 
+	registerAPI(r)
+
 	return r
 }
 
+// registerAPI mounts the versioned JSON API alongside the HTML admin
+// pages, reusing the same auth package but authenticating with a
+// bearer token (api.Login) instead of the cookie session.
+func registerAPI(r *mux.Router) {
+	r.HandleFunc("/api/v1/openapi.json", openAPIHandler).Methods("GET")
+
+	r.Handle("/api/v1/login", Handle(
+		api.Login(),
+	)).Methods("POST")
+
+	r.Handle("/api/v1/{show}/episodes", Handle(
+		auth.RequireAPIAuthorization(),
+		api.ListEpisodes(),
+	)).Methods("GET")
+
+	r.Handle("/api/v1/{show}/episodes", Handle(
+		auth.RequireAPIAuthorization(),
+		api.CreateEpisode(),
+	)).Methods("POST")
+
+	r.Handle("/api/v1/{show}/episodes/{id}", Handle(
+		auth.RequireAPIAuthorization(),
+		api.DeleteEpisode(),
+	)).Methods("DELETE")
+
+	r.Handle("/api/v1/{show}/config", Handle(
+		auth.RequireAPIAuthorization(),
+		api.GetConfig(),
+	)).Methods("GET")
+
+	r.Handle("/api/v1/{show}/config", Handle(
+		auth.RequireAPIAuthorization(),
+		api.PutConfig(),
+	)).Methods("PUT")
+}
+
+// downloadHandler serves a show's episode files, scoped to its own
+// podcasts/ directory so one show can't reach into another's.
+func downloadHandler(w http.ResponseWriter, r *http.Request) {
+	slug := mux.Vars(r)["show"]
+	prefix := "/" + slug + "/download/"
+	http.StripPrefix(prefix, http.FileServer(http.Dir(shows.PodcastsDir(slug)))).ServeHTTP(w, r)
+}
+
+// registerActivityPub enables the ActivityPub actor for the first show
+// that has opted in via its own config.json. The webfinger/actor
+// namespace is instance-wide, so (for now) only one show per instance
+// can federate; hosting several federated shows on one domain would
+// need its own webfinger resource per show, which is left for later.
+func registerActivityPub(r *mux.Router) {
+	slugs, err := shows.List()
+	if err != nil {
+		return
+	}
+
+	for _, slug := range slugs {
+		cnf, err := loadConfig(slug)
+		if err != nil || !cnf.EnableActivityPub {
+			continue
+		}
+
+		if err := activitypub.Enable(activitypub.Config{
+			Host:              cnf.PodcastURL,
+			PreferredUsername: cnf.ActivityPubUsername,
+			Name:              cnf.Name,
+			Summary:           cnf.Description,
+		}); err != nil {
+			log.Printf("could not enable activitypub for %s: %v", slug, err)
+			continue
+		}
+
+		r.HandleFunc("/actor", activitypub.ActorHandler).Methods("GET")
+		r.HandleFunc("/inbox", activitypub.InboxHandler).Methods("POST")
+		r.HandleFunc("/.well-known/webfinger", activitypub.WebfingerHandler).Methods("GET")
+		return
+	}
+}
+
 func loginHandler() common.Handler {
 	return func(rc *common.RouterContext, w http.ResponseWriter, r *http.Request) *common.HTTPError {
 
@@ -136,14 +283,19 @@ func loginHandler() common.Handler {
 			}
 		}
 
-		var u map[string]string
-		err = json.Unmarshal(d, &u) // Unmarshal into interface
+		var u map[string]auth.UserRecord
+		if err := json.Unmarshal(d, &u); err != nil {
+			return &common.HTTPError{
+				Message:    fmt.Sprintf("error in parsing users.json: %v", err),
+				StatusCode: http.StatusInternalServerError,
+			}
+		}
 
 		// Iterate through map until we find matching username
 		for k, v := range u {
-			if k == username && v == password {
-				// Create a cookie here because the credentials are correct
-				c, err := auth.CreateSession(&common.User{
+			if k == username && auth.CheckPassword(v.Password, password) {
+				// Create a session here because the credentials are correct
+				c, csrfToken, err := auth.CreateSession(&common.User{
 					Username: k,
 				})
 				if err != nil {
@@ -153,8 +305,10 @@ func loginHandler() common.Handler {
 					}
 				}
 
-				// r.AddCookie(c)
 				w.Header().Add("Set-Cookie", c.String())
+				// The admin UI needs this to set the X-CSRF-Token header on
+				// its own POSTs; it isn't itself sensitive.
+				w.Header().Set("X-CSRF-Token", csrfToken)
 				// And now redirect the user to admin page
 				http.Redirect(w, r, "/admin", http.StatusTemporaryRedirect)
 				return nil
@@ -168,26 +322,51 @@ func loginHandler() common.Handler {
 	}
 }
 
-// Handles /, /feed and /json endpoints
+// logoutHandler invalidates the caller's session and clears the
+// cookie.
+func logoutHandler() common.Handler {
+	return func(rc *common.RouterContext, w http.ResponseWriter, r *http.Request) *common.HTTPError {
+		if err := auth.Logout(r); err != nil {
+			return &common.HTTPError{
+				Message:    err.Error(),
+				StatusCode: http.StatusInternalServerError,
+			}
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     "pogo_session",
+			Value:    "",
+			Path:     "/",
+			HttpOnly: true,
+			MaxAge:   -1,
+		})
+		w.Write([]byte("logged out"))
+		return nil
+	}
+}
+
+// Handles /{show}/, /{show}/rss and /{show}/json
 func rootHandler() common.Handler {
 	return func(rc *common.RouterContext, w http.ResponseWriter, r *http.Request) *common.HTTPError {
+		slug := mux.Vars(r)["show"]
+		if !shows.Exists(slug) {
+			return &common.HTTPError{
+				Message:    fmt.Sprintf("%s: show not found", slug),
+				StatusCode: http.StatusNotFound,
+			}
+		}
 
 		var file string
-		switch r.URL.Path {
-		case "/rss":
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/rss"):
 			w.Header().Set("Content-Type", "application/rss+xml")
-			file = "assets/web/feed.rss"
-		case "/json":
+			file = shows.Dir(slug) + "/feed.rss"
+		case strings.HasSuffix(r.URL.Path, "/json"):
 			w.Header().Set("Content-Type", "application/json")
-			file = "assets/web/feed.json"
-		case "/":
-			w.Header().Set("Content-Type", "text/html")
-			file = "assets/web/index.html"
+			file = shows.Dir(slug) + "/feed.json"
 		default:
-			return &common.HTTPError{
-				Message:    fmt.Sprintf("%s: Not Found", r.URL.Path),
-				StatusCode: http.StatusNotFound,
-			}
+			w.Header().Set("Content-Type", "text/html")
+			file = shows.Dir(slug) + "/index.html"
 		}
 
 		return common.ReadAndServeFile(file, w)
@@ -196,11 +375,15 @@ func rootHandler() common.Handler {
 
 func adminHandler() common.Handler {
 	return func(rc *common.RouterContext, w http.ResponseWriter, r *http.Request) *common.HTTPError {
+		if slug, ok := mux.Vars(r)["show"]; ok {
+			_ = slug // the admin page itself is shared; it reads the show from the URL client-side
+		}
 		return common.ReadAndServeFile("assets/web/admin.html", w)
 	}
 }
 
-// Serve setup.html and config parameters
+// serveSetup creates a new show: GET renders setup.html, POST writes
+// shows/<slug>/config.json and creates its podcasts/ directory.
 func serveSetup() common.Handler {
 	return func(rc *common.RouterContext, w http.ResponseWriter, r *http.Request) *common.HTTPError {
 		if r.Method == "GET" {
@@ -208,14 +391,51 @@ func serveSetup() common.Handler {
 		}
 		r.ParseMultipartForm(32 << 20)
 
+		slug := strings.Join(r.Form["slug"], "")
+		if slug == "" {
+			return &common.HTTPError{
+				Message:    "slug is required",
+				StatusCode: http.StatusBadRequest,
+			}
+		}
+		if !slugPattern.MatchString(slug) {
+			return &common.HTTPError{
+				Message:    "slug must match " + slugPattern.String(),
+				StatusCode: http.StatusBadRequest,
+			}
+		}
+
+		// itunessubcategory inputs are paired with itunescategory by
+		// form position, same as the setup.html fields they come from.
+		subcategories := r.Form["itunessubcategory"]
+		var categories []Category
+		for i, name := range r.Form["itunescategory"] {
+			if name == "" {
+				continue
+			}
+			cat := Category{Name: name}
+			if i < len(subcategories) {
+				cat.Subcategory = subcategories[i]
+			}
+			categories = append(categories, cat)
+		}
+
 		// Parse form and convert to JSON
 		cnf := NewConfig{
-			strings.Join(r.Form["podcastname"], ""),  // Podcast name
-			strings.Join(r.Form["podcasthost"], ""),  // Podcast host
-			strings.Join(r.Form["podcastemail"], ""), // Podcast host email
-			"", // Podcast image
-			"", // Podcast location
-			"", // Podcast location
+			Name:        strings.Join(r.Form["podcastname"], ""),
+			Host:        strings.Join(r.Form["podcasthost"], ""),
+			Email:       strings.Join(r.Form["podcastemail"], ""),
+			Image:       strings.Join(r.Form["podcastimage"], ""),
+			PodcastURL:  strings.Join(r.Form["podcasturl"], ""),
+			Subtitle:    strings.Join(r.Form["itunessubtitle"], ""),
+			Summary:     strings.Join(r.Form["itunessummary"], ""),
+			OwnerName:   strings.Join(r.Form["itunesownername"], ""),
+			OwnerEmail:  strings.Join(r.Form["itunesowneremail"], ""),
+			Explicit:    strings.Join(r.Form["itunesexplicit"], "") == "on",
+			Type:        strings.Join(r.Form["itunestype"], ""),
+			Language:    strings.Join(r.Form["language"], ""),
+			Copyright:   strings.Join(r.Form["copyright"], ""),
+			Categories:  categories,
 		}
 
 		b, err := json.Marshal(cnf)
@@ -223,8 +443,80 @@ func serveSetup() common.Handler {
 			panic(err)
 		}
 
-		ioutil.WriteFile("assets/config/config.json", b, 0644)
+		if err := os.MkdirAll(shows.PodcastsDir(slug), 0755); err != nil {
+			return &common.HTTPError{
+				Message:    fmt.Sprintf("error creating show directory: %v", err),
+				StatusCode: http.StatusInternalServerError,
+			}
+		}
+
+		if err := ioutil.WriteFile(shows.ConfigPath(slug), b, 0644); err != nil {
+			return &common.HTTPError{
+				Message:    fmt.Sprintf("error writing show config: %v", err),
+				StatusCode: http.StatusInternalServerError,
+			}
+		}
+
+		// Grant the creator access to the show they just made, or
+		// every /admin/{slug}/... call they make next gets a 403 from
+		// CanAccessShow until an operator hand-edits users.json.
+		if err := auth.GrantShowAccess(rc.User.Username, slug); err != nil {
+			return &common.HTTPError{
+				Message:    fmt.Sprintf("error granting show access: %v", err),
+				StatusCode: http.StatusInternalServerError,
+			}
+		}
+
+		if OnShowCreated != nil {
+			OnShowCreated(slug)
+		}
+
 		w.Write([]byte("Done"))
 		return nil
 	}
 }
+
+// openAPIHandler serves a minimal OpenAPI 3.0 description of /api/v1/,
+// so third-party clients (mobile uploaders, CI publishers) can
+// generate a client instead of reading the handlers.
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	spec := map[string]interface{}{
+		"openapi": "3.0.0",
+		"info": map[string]string{
+			"title":   "pogo API",
+			"version": "v1",
+		},
+		"paths": map[string]interface{}{
+			"/api/v1/login": map[string]interface{}{
+				"post": map[string]string{"summary": "Exchange a username/password for a bearer token"},
+			},
+			"/api/v1/{show}/episodes": map[string]interface{}{
+				"get":  map[string]string{"summary": "List a show's episodes"},
+				"post": map[string]string{"summary": "Upload a new episode"},
+			},
+			"/api/v1/{show}/episodes/{id}": map[string]interface{}{
+				"delete": map[string]string{"summary": "Delete an episode"},
+			},
+			"/api/v1/{show}/config": map[string]interface{}{
+				"get": map[string]string{"summary": "Read a show's config.json"},
+				"put": map[string]string{"summary": "Replace a show's config.json"},
+			},
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(spec)
+}
+
+// loadConfig reads a show's config.json, the same file GenerateRss
+// reads, so router-level feature flags (like EnableActivityPub) stay
+// in one place.
+func loadConfig(slug string) (NewConfig, error) {
+	var cnf NewConfig
+	d, err := ioutil.ReadFile(shows.ConfigPath(slug))
+	if err != nil {
+		return cnf, err
+	}
+	err = json.Unmarshal(d, &cnf)
+	return cnf, err
+}
@@ -0,0 +1,53 @@
+/* shows.go
+ *
+ * A pogo instance can host more than one show. Each show is a slug
+ * under shows/, with its own config.json and podcasts/ directory of
+ * episodes and shownotes - the same layout the single-show instance
+ * used to keep at the repo root.
+ */
+package shows
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+const Root = "shows"
+
+// Dir returns the root directory for a show.
+func Dir(slug string) string {
+	return Root + "/" + slug
+}
+
+// ConfigPath returns the path to a show's config.json.
+func ConfigPath(slug string) string {
+	return Dir(slug) + "/config.json"
+}
+
+// PodcastsDir returns the path to a show's episodes directory.
+func PodcastsDir(slug string) string {
+	return Dir(slug) + "/podcasts"
+}
+
+// List returns the slug of every show hosted on this instance, i.e.
+// every directory under shows/.
+func List() ([]string, error) {
+	entries, err := ioutil.ReadDir(Root)
+	if err != nil {
+		return nil, err
+	}
+
+	var slugs []string
+	for _, e := range entries {
+		if e.IsDir() {
+			slugs = append(slugs, e.Name())
+		}
+	}
+	return slugs, nil
+}
+
+// Exists reports whether a show with the given slug has been set up.
+func Exists(slug string) bool {
+	_, err := os.Stat(ConfigPath(slug))
+	return err == nil
+}
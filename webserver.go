@@ -0,0 +1,37 @@
+/* webserver.go
+ *
+ * Entry point. `pogo hash-password` dispatches to the CLI subcommand in
+ * hash_password.go instead of starting the server; with no arguments,
+ * it generates every show's feed once, starts the fsnotify watcher
+ * that regenerates them on change, wires router.OnShowCreated so a
+ * show created through /setup is picked up without a restart, and
+ * serves the router.
+ */
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/gmemstr/pogo/router"
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "hash-password" {
+		RunHashPassword(os.Args[2:])
+		return
+	}
+
+	GenerateAllRss()
+
+	router.OnShowCreated = func(slug string) {
+		GenerateRss(slug)
+		addShowWatch(slug)
+	}
+
+	go watch()
+
+	log.Fatal(http.ListenAndServe(":8080", router.Init()))
+}